@@ -0,0 +1,80 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package controller builds a single Helm-backed controller for one watched
+// GVK. It exists so that both the "run" command (which starts a controller
+// per entry in watches.yaml) and the "run-single" command (which starts
+// exactly one, without a watches.yaml at all) share the same setup path.
+package controller
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/joelanford/helm-operator/pkg/annotation"
+	"github.com/joelanford/helm-operator/pkg/client"
+	"github.com/joelanford/helm-operator/pkg/reconciler"
+	"github.com/joelanford/helm-operator/pkg/watches"
+)
+
+// Defaults holds the operator-wide fallbacks applied to a watch when it
+// doesn't set its own value.
+type Defaults struct {
+	MaxConcurrentReconciles int
+	ReconcilePeriod         time.Duration
+	Selector                labels.Selector
+}
+
+// SetupWithManager builds the reconciler for w and registers it with mgr,
+// applying d for any fields w leaves unset.
+func SetupWithManager(mgr ctrl.Manager, w watches.Watch, d Defaults) error {
+	reconcilePeriod := d.ReconcilePeriod
+	if w.ReconcilePeriod != nil {
+		reconcilePeriod = w.ReconcilePeriod.Duration
+	}
+
+	maxConcurrentReconciles := d.MaxConcurrentReconciles
+	if w.MaxConcurrentReconciles != nil {
+		maxConcurrentReconciles = *w.MaxConcurrentReconciles
+	}
+
+	selector := d.Selector
+	if w.Selector != nil {
+		selector = selector.Add(w.Selector.Requirements()...)
+	}
+
+	r, err := reconciler.New(
+		reconciler.WithChart(*w.Chart),
+		reconciler.WithGroupVersionKind(w.GroupVersionKind),
+		reconciler.WithActionClientGetter(client.NewActionClientGetter(mgr.GetConfig())),
+		reconciler.WithOverrideValues(w.OverrideValues),
+		reconciler.SkipDependentWatches(w.WatchDependentResources != nil && !*w.WatchDependentResources),
+		reconciler.WithMaxConcurrentReconciles(maxConcurrentReconciles),
+		reconciler.WithReconcilePeriod(reconcilePeriod),
+		reconciler.WithSelector(selector),
+		reconciler.WithValueSources(w.ValueSources...),
+		reconciler.WithInstallAnnotations(annotation.DefaultInstallAnnotations...),
+		reconciler.WithUpgradeAnnotations(annotation.DefaultUpgradeAnnotations...),
+		reconciler.WithUninstallAnnotations(annotation.DefaultUninstallAnnotations...),
+	)
+	if err != nil {
+		return err
+	}
+
+	return r.SetupWithManager(mgr)
+}
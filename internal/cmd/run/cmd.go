@@ -18,20 +18,31 @@ package run
 
 import (
 	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
 	"os"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	zapl "sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	"github.com/joelanford/helm-operator/internal/cmd/controller"
 	"github.com/joelanford/helm-operator/internal/version"
-	"github.com/joelanford/helm-operator/pkg/annotation"
+	"github.com/joelanford/helm-operator/pkg/client"
 	"github.com/joelanford/helm-operator/pkg/manager"
-	"github.com/joelanford/helm-operator/pkg/reconciler"
 	"github.com/joelanford/helm-operator/pkg/watches"
 )
 
@@ -60,9 +71,24 @@ type run struct {
 	leaderElectionID        string
 	leaderElectionNamespace string
 
+	leaderElectionLeaseDuration time.Duration
+	leaderElectionRenewDeadline time.Duration
+	leaderElectionRetryPeriod   time.Duration
+	leaderElectionResourceLock  string
+
 	watchesFile                    string
 	defaultMaxConcurrentReconciles int
 	defaultReconcilePeriod         time.Duration
+
+	watchLabelSelector string
+
+	only    []string
+	exclude []string
+
+	healthProbeBindAddress string
+
+	syncPeriod             time.Duration
+	syncPeriodJitterFactor float64
 }
 
 func (r *run) bindFlags(fs *pflag.FlagSet) {
@@ -73,10 +99,31 @@ func (r *run) bindFlags(fs *pflag.FlagSet) {
 		"Name of the configmap that is used for holding the leader lock.")
 	fs.StringVar(&r.leaderElectionNamespace, "leader-election-namespace", "",
 		"Namespace in which to create the leader election configmap for holding the leader lock (required if running locally with leader election enabled).")
+	fs.DurationVar(&r.leaderElectionLeaseDuration, "leader-election-lease-duration", 15*time.Second,
+		"The duration that non-leader candidates will wait to force acquire leadership.")
+	fs.DurationVar(&r.leaderElectionRenewDeadline, "leader-election-renew-deadline", 10*time.Second,
+		"The duration that the acting leader will retry refreshing leadership before giving up.")
+	fs.DurationVar(&r.leaderElectionRetryPeriod, "leader-election-retry-period", 2*time.Second,
+		"The duration leader election clients should wait between tries of actions.")
+	fs.StringVar(&r.leaderElectionResourceLock, "leader-election-resource-lock", resourcelock.LeasesResourceLock,
+		"The resource lock to use for leader election. One of: configmaps, leases, configmapsleases, endpointsleases.")
 
 	fs.StringVar(&r.watchesFile, "watches-file", "./watches.yaml", "Path to watches.yaml file.")
 	fs.DurationVar(&r.defaultReconcilePeriod, "reconcile-period", time.Minute, "Default reconcile period for controllers (use 0 to disable periodic reconciliation)")
 	fs.IntVar(&r.defaultMaxConcurrentReconciles, "max-concurrent-reconciles", runtime.NumCPU(), "Default maximum number of concurrent reconciles for controllers.")
+
+	fs.StringVar(&r.watchLabelSelector, "watch-label-selector", "", "A label selector that restricts the custom resources watched and cached by every controller to those matching the selector; dependent resources are not filtered by it. A per-watch \"selector\" field in watches.yaml is ANDed with this value. Use this to shard ownership of CRs across multiple helm-operator replicas, e.g. one replica per shard label.")
+
+	fs.StringArrayVar(&r.only, "only", nil, "Only start controllers for this group/version/kind (repeatable). If unset, all watches.yaml entries are started. Mutually exclusive with --exclude.")
+	fs.StringArrayVar(&r.exclude, "exclude", nil, "Do not start a controller for this group/version/kind (repeatable). Mutually exclusive with --only.")
+
+	fs.StringVar(&r.healthProbeBindAddress, "health-probe-bind-address", ":8081", "The address the liveness and readiness probe endpoints bind to.")
+
+	fs.DurationVar(&r.syncPeriod, "sync-period", 10*time.Hour,
+		"The minimum interval at which watched resources are reconciled via a full informer relist. This is distinct from the per-watch \"reconcile-period\", which schedules periodic reconciles independent of informer activity.")
+	fs.Float64Var(&r.syncPeriodJitterFactor, "sync-period-jitter-factor", 0.1,
+		"Jitter factor applied to --sync-period so that multiple helm-operator replicas don't all relist in lockstep. "+
+			"This is a single manager-wide resync period; it does not vary per watched GVK.")
 }
 
 var log = logf.Log.WithName("cmd")
@@ -103,12 +150,58 @@ func (r *run) run(cmd *cobra.Command) {
 		}
 	}
 
+	ws, err := watches.Load(r.watchesFile)
+	if err != nil {
+		log.Error(err, "unable to load watches.yaml", "path", r.watchesFile)
+		os.Exit(1)
+	}
+
+	ws, err = filterWatches(ws, r.only, r.exclude)
+	if err != nil {
+		log.Error(err, "invalid --only/--exclude")
+		os.Exit(1)
+	}
+
+	watchLabelSelector, err := labels.Parse(r.watchLabelSelector)
+	if err != nil {
+		log.Error(err, "unable to parse --watch-label-selector", "selector", r.watchLabelSelector)
+		os.Exit(1)
+	}
+
+	// Every watched GVK gets a cache selector so the manager's informers only download the custom
+	// resources this operator instance actually cares about. The release's other secondary
+	// resources get no selector of their own; only Secrets and ConfigMaps are scoped, below.
+	selectorsByObject := cache.SelectorsByObject{}
+	for _, w := range ws {
+		selectorsByObject[unstructuredFor(w.GroupVersionKind)] = cache.ObjectSelector{Label: mergeSelector(watchLabelSelector, w)}
+	}
+	// Helm's storage driver labels every release's storage object - a Secret with the default
+	// "secret" driver, a ConfigMap with the "configmap" driver - with "owner=helm", regardless of
+	// which chart or CR created the release. Scoping the cache to that label on both GVKs, rather
+	// than caching every Secret/ConfigMap in the watched namespaces, is what actually keeps memory
+	// down on clusters with lots of unrelated ones, whichever storage driver the operator uses.
+	for _, storageGVK := range []schema.GroupVersionKind{
+		{Group: "", Version: "v1", Kind: "Secret"},
+		{Group: "", Version: "v1", Kind: "ConfigMap"},
+	} {
+		selectorsByObject[unstructuredFor(storageGVK)] = cache.ObjectSelector{Label: helmStorageSelector}
+	}
+
+	syncPeriod := jitter(r.syncPeriod, r.syncPeriodJitterFactor)
+
 	options := ctrl.Options{
-		MetricsBindAddress:      r.metricsAddr,
-		LeaderElection:          r.enableLeaderElection,
-		LeaderElectionID:        r.leaderElectionID,
-		LeaderElectionNamespace: r.leaderElectionNamespace,
-		NewClient:               manager.NewDelegatingClientFunc(),
+		MetricsBindAddress:         r.metricsAddr,
+		HealthProbeBindAddress:     r.healthProbeBindAddress,
+		SyncPeriod:                 &syncPeriod,
+		LeaderElection:             r.enableLeaderElection,
+		LeaderElectionID:           r.leaderElectionID,
+		LeaderElectionNamespace:    r.leaderElectionNamespace,
+		LeaderElectionResourceLock: r.leaderElectionResourceLock,
+		LeaseDuration:              &r.leaderElectionLeaseDuration,
+		RenewDeadline:              &r.leaderElectionRenewDeadline,
+		RetryPeriod:                &r.leaderElectionRetryPeriod,
+		NewClient:                  manager.NewDelegatingClientFunc(),
+		NewCache:                   cache.BuilderWithOptions(cache.Options{SelectorsByObject: selectorsByObject}),
 	}
 	manager.ConfigureWatchNamespaces(&options, log)
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
@@ -117,49 +210,191 @@ func (r *run) run(cmd *cobra.Command) {
 		os.Exit(1)
 	}
 
-	ws, err := watches.Load(r.watchesFile)
-	if err != nil {
-		log.Error(err, "unable to load watches.yaml", "path", r.watchesFile)
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		log.Error(err, "unable to register liveness check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("charts-loaded", readyzChartsLoaded(ws)); err != nil {
+		log.Error(err, "unable to register readiness check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("helm-client", readyzHelmClientList(mgr.GetConfig(), ws, probeNamespace(options.Namespace))); err != nil {
+		log.Error(err, "unable to register readiness check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("informers-synced", readyzInformersSynced(mgr, ws)); err != nil {
+		log.Error(err, "unable to register readiness check")
+		os.Exit(1)
+	}
+
+	defaults := controller.Defaults{
+		MaxConcurrentReconciles: r.defaultMaxConcurrentReconciles,
+		ReconcilePeriod:         r.defaultReconcilePeriod,
+		Selector:                watchLabelSelector,
+	}
 
 	for _, w := range ws {
-		reconcilePeriod := r.defaultReconcilePeriod
-		if w.ReconcilePeriod != nil {
-			reconcilePeriod = w.ReconcilePeriod.Duration
+		if err := controller.SetupWithManager(mgr, w, defaults); err != nil {
+			log.Error(err, "unable to create controller", "controller", "Helm", "gvk", w.GroupVersionKind)
+			os.Exit(1)
 		}
+		log.Info("configured watch", "gvk", w.GroupVersionKind, "chartPath", w.ChartPath)
+	}
 
-		maxConcurrentReconciles := r.defaultMaxConcurrentReconciles
-		if w.MaxConcurrentReconciles != nil {
-			maxConcurrentReconciles = *w.MaxConcurrentReconciles
+	log.Info("starting manager")
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		log.Error(err, "problem running manager")
+		os.Exit(1)
+	}
+}
+
+// helmStorageSelector matches the "owner=helm" label Helm's storage drivers put on every
+// release's storage object, regardless of chart or CR. Used to scope the cache's Secret and
+// ConfigMap informers down to only Helm release storage objects.
+var helmStorageSelector = labels.SelectorFromSet(labels.Set{"owner": "helm"})
+
+// mergeSelector ANDs the operator-wide --watch-label-selector with a watch's own
+// per-GVK "selector" field, if one is set.
+func mergeSelector(base labels.Selector, w watches.Watch) labels.Selector {
+	if w.Selector == nil {
+		return base
+	}
+	return base.Add(w.Selector.Requirements()...)
+}
+
+// unstructuredFor returns the typed-but-kindless object cache.Options.SelectorsByObject
+// keys on for a GVK that, like every type this operator watches, has no compiled-in Go type.
+func unstructuredFor(gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	return u
+}
+
+// readyzChartsLoaded reports NotReady until every watch's chart has been loaded from disk.
+func readyzChartsLoaded(ws []watches.Watch) healthz.Checker {
+	return func(_ *http.Request) error {
+		for _, w := range ws {
+			if w.Chart == nil {
+				return fmt.Errorf("chart for %s has not loaded", w.GroupVersionKind)
+			}
 		}
+		return nil
+	}
+}
 
-		r, err := reconciler.New(
-			reconciler.WithChart(*w.Chart),
-			reconciler.WithGroupVersionKind(w.GroupVersionKind),
-			reconciler.WithOverrideValues(w.OverrideValues),
-			reconciler.SkipDependentWatches(w.WatchDependentResources != nil && !*w.WatchDependentResources),
-			reconciler.WithMaxConcurrentReconciles(maxConcurrentReconciles),
-			reconciler.WithReconcilePeriod(reconcilePeriod),
-			reconciler.WithInstallAnnotations(annotation.DefaultInstallAnnotations...),
-			reconciler.WithUpgradeAnnotations(annotation.DefaultUpgradeAnnotations...),
-			reconciler.WithUninstallAnnotations(annotation.DefaultUninstallAnnotations...),
-		)
+// readyzHelmClientList reports NotReady until the Helm action client can list releases in at
+// least one namespace representative of the watches this operator manages.
+func readyzHelmClientList(cfg *rest.Config, ws []watches.Watch, namespace string) healthz.Checker {
+	return func(_ *http.Request) error {
+		if len(ws) == 0 {
+			return nil
+		}
+		actionClient, err := client.NewActionClientGetter(cfg).ActionClientFor(ws[0].GroupVersionKind, namespace)
 		if err != nil {
-			log.Error(err, "unable to create helm reconciler", "controller", "Helm")
-			os.Exit(1)
+			return fmt.Errorf("unable to create helm action client: %w", err)
 		}
+		if _, err := actionClient.List(); err != nil {
+			return fmt.Errorf("helm action client cannot list releases: %w", err)
+		}
+		return nil
+	}
+}
 
-		if err := r.SetupWithManager(mgr); err != nil {
-			log.Error(err, "unable to create controller", "controller", "Helm")
-			os.Exit(1)
+// probeNamespace picks the namespace the helm-client readiness check uses to exercise a
+// representative Helm list call. It need not contain any releases, only be one the operator
+// is actually configured (and RBAC'd) to talk to - mgrNamespace is empty when the operator
+// watches all namespaces, in which case "default" is as good a guess as any.
+func probeNamespace(mgrNamespace string) string {
+	if mgrNamespace == "" {
+		return "default"
+	}
+	return mgrNamespace
+}
+
+// jitter adds up to factor*period of random variance to period. With many helm-operator
+// replicas sharing one --sync-period, an unjittered period causes every replica's informers to
+// relist in the same instant; a small spread smooths that out. It applies manager-wide, so it
+// does not desync the separate GVKs watched by a single operator process from one another - that
+// would require a per-GVK resync period, which this controller-runtime cache does not support.
+func jitter(period time.Duration, factor float64) time.Duration {
+	if factor <= 0 {
+		return period
+	}
+	return period + time.Duration(rand.Float64()*factor*float64(period))
+}
+
+// filterWatches narrows ws down to the watches selected by --only, or all watches except
+// those named by --exclude. Specifying both is rejected, since their precedence would be
+// ambiguous.
+func filterWatches(ws []watches.Watch, only, exclude []string) ([]watches.Watch, error) {
+	if len(only) > 0 && len(exclude) > 0 {
+		return nil, fmt.Errorf("--only and --exclude are mutually exclusive")
+	}
+	if len(only) == 0 && len(exclude) == 0 {
+		return ws, nil
+	}
+
+	onlySet, err := gvkSet(only)
+	if err != nil {
+		return nil, err
+	}
+	excludeSet, err := gvkSet(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []watches.Watch
+	for _, w := range ws {
+		switch {
+		case len(onlySet) > 0:
+			if _, ok := onlySet[w.GroupVersionKind]; ok {
+				filtered = append(filtered, w)
+			}
+		default:
+			if _, ok := excludeSet[w.GroupVersionKind]; !ok {
+				filtered = append(filtered, w)
+			}
 		}
-		log.Info("configured watch", "gvk", w.GroupVersionKind, "chartPath", w.ChartPath, "maxConcurrentReconciles", maxConcurrentReconciles, "reconcilePeriod", reconcilePeriod)
 	}
+	return filtered, nil
+}
 
-	log.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
-		log.Error(err, "problem running manager")
-		os.Exit(1)
+// gvkSet parses a list of "group/version/kind" strings into a lookup set.
+func gvkSet(gvks []string) (map[schema.GroupVersionKind]struct{}, error) {
+	set := make(map[schema.GroupVersionKind]struct{}, len(gvks))
+	for _, s := range gvks {
+		gvk, err := parseGVK(s)
+		if err != nil {
+			return nil, err
+		}
+		set[gvk] = struct{}{}
+	}
+	return set, nil
+}
+
+// parseGVK parses a "group/version/kind" string, e.g. "example.com/v1/TestApp".
+func parseGVK(s string) (schema.GroupVersionKind, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return schema.GroupVersionKind{}, fmt.Errorf("invalid group/version/kind %q, expected form group/version/kind", s)
+	}
+	return schema.GroupVersionKind{Group: parts[0], Version: parts[1], Kind: parts[2]}, nil
+}
+
+// readyzInformersSynced reports NotReady until the informer for every watched GVK has
+// completed its initial sync. Each watch's informer is checked individually, rather than
+// blocking on the whole cache, so the error names the GVK that's actually still syncing.
+func readyzInformersSynced(mgr ctrl.Manager, ws []watches.Watch) healthz.Checker {
+	return func(req *http.Request) error {
+		for _, w := range ws {
+			informer, err := mgr.GetCache().GetInformerForKind(req.Context(), w.GroupVersionKind)
+			if err != nil {
+				return fmt.Errorf("get informer for %s: %w", w.GroupVersionKind, err)
+			}
+			if !informer.HasSynced() {
+				return fmt.Errorf("informer for %s has not synced", w.GroupVersionKind)
+			}
+		}
+		return nil
 	}
 }
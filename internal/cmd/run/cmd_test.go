@@ -0,0 +1,144 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package run
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/joelanford/helm-operator/pkg/watches"
+)
+
+func TestParseGVK(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		want    schema.GroupVersionKind
+		wantErr bool
+	}{
+		{name: "valid", in: "example.com/v1/TestApp", want: schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "TestApp"}},
+		{name: "core group is empty string, not omitted", in: "/v1/Secret", want: schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Secret"}},
+		{name: "missing kind", in: "example.com/v1", wantErr: true},
+		{name: "too many segments", in: "example.com/v1/TestApp/extra", wantErr: true},
+		{name: "empty string", in: "", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseGVK(tc.in)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseGVK(%q) expected an error, got none", tc.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseGVK(%q) error = %v", tc.in, err)
+			}
+			if got != tc.want {
+				t.Fatalf("parseGVK(%q) = %v, want %v", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFilterWatches(t *testing.T) {
+	appGVK := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "TestApp"}
+	dbGVK := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "TestDB"}
+	ws := []watches.Watch{
+		{GroupVersionKind: appGVK},
+		{GroupVersionKind: dbGVK},
+	}
+
+	t.Run("no filter returns everything", func(t *testing.T) {
+		got, err := filterWatches(ws, nil, nil)
+		if err != nil {
+			t.Fatalf("filterWatches() error = %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("expected 2 watches, got %d", len(got))
+		}
+	})
+
+	t.Run("only keeps just the named GVKs", func(t *testing.T) {
+		got, err := filterWatches(ws, []string{"example.com/v1/TestApp"}, nil)
+		if err != nil {
+			t.Fatalf("filterWatches() error = %v", err)
+		}
+		if len(got) != 1 || got[0].GroupVersionKind != appGVK {
+			t.Fatalf("expected only %v, got %v", appGVK, got)
+		}
+	})
+
+	t.Run("exclude drops just the named GVKs", func(t *testing.T) {
+		got, err := filterWatches(ws, nil, []string{"example.com/v1/TestApp"})
+		if err != nil {
+			t.Fatalf("filterWatches() error = %v", err)
+		}
+		if len(got) != 1 || got[0].GroupVersionKind != dbGVK {
+			t.Fatalf("expected only %v, got %v", dbGVK, got)
+		}
+	})
+
+	t.Run("only and exclude together is an error", func(t *testing.T) {
+		_, err := filterWatches(ws, []string{"example.com/v1/TestApp"}, []string{"example.com/v1/TestDB"})
+		if err == nil {
+			t.Fatal("expected an error when both --only and --exclude are set")
+		}
+	})
+
+	t.Run("malformed --only entry is an error", func(t *testing.T) {
+		_, err := filterWatches(ws, []string{"not-a-gvk"}, nil)
+		if err == nil {
+			t.Fatal("expected an error for a malformed --only entry")
+		}
+	})
+
+	t.Run("malformed --exclude entry is an error", func(t *testing.T) {
+		_, err := filterWatches(ws, nil, []string{"not-a-gvk"})
+		if err == nil {
+			t.Fatal("expected an error for a malformed --exclude entry")
+		}
+	})
+}
+
+func TestJitter(t *testing.T) {
+	const period = 10 * time.Hour
+
+	t.Run("factor of zero returns period unchanged", func(t *testing.T) {
+		if got := jitter(period, 0); got != period {
+			t.Fatalf("jitter(period, 0) = %v, want %v unchanged", got, period)
+		}
+	})
+
+	t.Run("negative factor returns period unchanged", func(t *testing.T) {
+		if got := jitter(period, -1); got != period {
+			t.Fatalf("jitter(period, -1) = %v, want %v unchanged", got, period)
+		}
+	})
+
+	t.Run("positive factor adds bounded variance", func(t *testing.T) {
+		const factor = 0.1
+		for i := 0; i < 100; i++ {
+			got := jitter(period, factor)
+			if got < period || got > period+time.Duration(factor*float64(period)) {
+				t.Fatalf("jitter(period, %v) = %v, want within [%v, %v]", factor, got, period, period+time.Duration(factor*float64(period)))
+			}
+		}
+	})
+}
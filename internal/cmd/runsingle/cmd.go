@@ -0,0 +1,152 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runsingle
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	zapl "sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/joelanford/helm-operator/internal/cmd/controller"
+	"github.com/joelanford/helm-operator/pkg/chart"
+	"github.com/joelanford/helm-operator/pkg/manager"
+	"github.com/joelanford/helm-operator/pkg/watches"
+)
+
+var log = logf.Log.WithName("cmd")
+
+// NewCmd returns the "run-single" command, which runs exactly one reconciler for one chart
+// and GVK without a watches.yaml. It's useful for local development, and for splitting a
+// heavy operator into several Deployments that each own a subset of GVKs and can be scaled
+// and tuned independently.
+func NewCmd() *cobra.Command {
+	r := runSingle{}
+	zapfs := flag.NewFlagSet("zap", flag.ExitOnError)
+	opts := &zapl.Options{}
+	opts.BindFlags(zapfs)
+
+	cmd := &cobra.Command{
+		Use:   "run-single",
+		Short: "Run a single reconciler for one chart and GVK, without a watches.yaml",
+		Run: func(cmd *cobra.Command, _ []string) {
+			logf.SetLogger(zapl.New(zapl.UseFlagOptions(opts)))
+			r.run(cmd)
+		},
+	}
+	r.bindFlags(cmd.Flags())
+	cmd.Flags().AddGoFlagSet(zapfs)
+	return cmd
+}
+
+type runSingle struct {
+	metricsAddr             string
+	healthProbeBindAddr     string
+	chartPath               string
+	gvkStr                  string
+	maxConcurrentReconciles int
+	reconcilePeriod         time.Duration
+}
+
+func (r *runSingle) bindFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&r.metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	fs.StringVar(&r.healthProbeBindAddr, "health-probe-bind-address", ":8081", "The address the liveness and readiness probe endpoints bind to.")
+	fs.StringVar(&r.chartPath, "chart", "", "Path to the chart this reconciler installs/upgrades (required).")
+	fs.StringVar(&r.gvkStr, "gvk", "", "The group/version/kind this reconciler watches, e.g. example.com/v1/TestApp (required).")
+	fs.DurationVar(&r.reconcilePeriod, "reconcile-period", time.Minute, "Periodic reconcile period (use 0 to disable).")
+	fs.IntVar(&r.maxConcurrentReconciles, "max-concurrent-reconciles", runtime.NumCPU(), "Maximum number of concurrent reconciles.")
+}
+
+func (r *runSingle) run(cmd *cobra.Command) {
+	if r.chartPath == "" || r.gvkStr == "" {
+		log.Error(fmt.Errorf("--chart and --gvk are required"), "invalid flags")
+		os.Exit(1)
+	}
+
+	gvk, err := parseGVK(r.gvkStr)
+	if err != nil {
+		log.Error(err, "unable to parse --gvk", "gvk", r.gvkStr)
+		os.Exit(1)
+	}
+
+	chrt, err := chart.Load(r.chartPath)
+	if err != nil {
+		log.Error(err, "unable to load chart", "path", r.chartPath)
+		os.Exit(1)
+	}
+
+	options := ctrl.Options{
+		MetricsBindAddress:     r.metricsAddr,
+		HealthProbeBindAddress: r.healthProbeBindAddr,
+		NewClient:              manager.NewDelegatingClientFunc(),
+	}
+	manager.ConfigureWatchNamespaces(&options, log)
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
+	if err != nil {
+		log.Error(err, "unable to start manager")
+		os.Exit(1)
+	}
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		log.Error(err, "unable to register liveness check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("ping", healthz.Ping); err != nil {
+		log.Error(err, "unable to register readiness check")
+		os.Exit(1)
+	}
+
+	w := watches.Watch{
+		GroupVersionKind: gvk,
+		ChartPath:        r.chartPath,
+		Chart:            chrt,
+	}
+	defaults := controller.Defaults{
+		MaxConcurrentReconciles: r.maxConcurrentReconciles,
+		ReconcilePeriod:         r.reconcilePeriod,
+		Selector:                labels.Everything(),
+	}
+	if err := controller.SetupWithManager(mgr, w, defaults); err != nil {
+		log.Error(err, "unable to create controller", "gvk", gvk)
+		os.Exit(1)
+	}
+
+	log.Info("starting manager", "gvk", gvk, "chart", r.chartPath)
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		log.Error(err, "problem running manager")
+		os.Exit(1)
+	}
+}
+
+// parseGVK parses a "group/version/kind" string, e.g. "example.com/v1/TestApp".
+func parseGVK(s string) (schema.GroupVersionKind, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 {
+		return schema.GroupVersionKind{}, fmt.Errorf("invalid group/version/kind %q, expected form group/version/kind", s)
+	}
+	return schema.GroupVersionKind{Group: parts[0], Version: parts[1], Kind: parts[2]}, nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package client wraps the Helm action package behind a small, namespace- and
+// GVK-scoped interface, so the rest of the operator never constructs a Helm
+// action.Configuration directly.
+package client
+
+import (
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var log = logf.Log.WithName("client")
+
+// ActionInterface is the subset of Helm's release lifecycle actions a reconciler needs,
+// scoped to a single release name within the namespace it was built for.
+type ActionInterface interface {
+	// Get returns the currently deployed release, or an error satisfying
+	// errors.Is(err, driver.ErrReleaseNotFound) if none exists.
+	Get(name string) (*release.Release, error)
+	// List returns every release this client's namespace's storage driver knows about. Used by
+	// readyzHelmClientList as a cheap storage-reachability probe, independent of any one release.
+	List() ([]*release.Release, error)
+	// Install creates a new release named name from chrt with vals, recording description (if
+	// non-empty) against it.
+	Install(name string, chrt *chart.Chart, vals chartutil.Values, description string) (*release.Release, error)
+	// Upgrade updates the release named name to chrt with vals, recording description (if
+	// non-empty) against it.
+	Upgrade(name string, chrt *chart.Chart, vals chartutil.Values, description string) (*release.Release, error)
+	// Uninstall removes the release named name, recording description (if non-empty) against it.
+	Uninstall(name string, description string) (*release.UninstallReleaseResponse, error)
+}
+
+// ActionClientGetter returns a Helm action client scoped to a custom resource's GVK and
+// namespace. It exists so callers don't need to know how a *rest.Config becomes a Helm storage
+// backend.
+type ActionClientGetter interface {
+	ActionClientFor(gvk schema.GroupVersionKind, namespace string) (ActionInterface, error)
+}
+
+type actionClientGetter struct {
+	cfg *rest.Config
+}
+
+// NewActionClientGetter returns an ActionClientGetter that talks to the cluster described by cfg.
+func NewActionClientGetter(cfg *rest.Config) ActionClientGetter {
+	return &actionClientGetter{cfg: cfg}
+}
+
+func (g *actionClientGetter) ActionClientFor(gvk schema.GroupVersionKind, namespace string) (ActionInterface, error) {
+	actionConfig := new(action.Configuration)
+	if err := actionConfig.Init(newRESTClientGetter(g.cfg, namespace), namespace, "secret", debugLog); err != nil {
+		return nil, fmt.Errorf("initialize helm action config for %s in namespace %q: %w", gvk, namespace, err)
+	}
+	return &actionClient{cfg: actionConfig, namespace: namespace}, nil
+}
+
+type actionClient struct {
+	cfg       *action.Configuration
+	namespace string
+}
+
+func (c *actionClient) Get(name string) (*release.Release, error) {
+	return action.NewGet(c.cfg).Run(name)
+}
+
+func (c *actionClient) List() ([]*release.Release, error) {
+	return action.NewList(c.cfg).Run()
+}
+
+func (c *actionClient) Install(name string, chrt *chart.Chart, vals chartutil.Values, description string) (*release.Release, error) {
+	install := action.NewInstall(c.cfg)
+	install.ReleaseName = name
+	install.Namespace = c.namespace
+	install.Description = description
+	return install.Run(chrt, vals)
+}
+
+func (c *actionClient) Upgrade(name string, chrt *chart.Chart, vals chartutil.Values, description string) (*release.Release, error) {
+	upgrade := action.NewUpgrade(c.cfg)
+	upgrade.Description = description
+	return upgrade.Run(name, chrt, vals)
+}
+
+func (c *actionClient) Uninstall(name string, description string) (*release.UninstallReleaseResponse, error) {
+	uninstall := action.NewUninstall(c.cfg)
+	uninstall.Description = description
+	return uninstall.Run(name)
+}
+
+func debugLog(format string, v ...interface{}) {
+	log.V(1).Info(fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ownerRefDetector decides whether a dependent-resource GVK can carry an owner reference back
+// to a reconciler's primary GVK, probing the RESTMapper once per (owner, dependent) pair and
+// caching the result so repeated dependent-watch setup doesn't repeat discovery calls.
+type ownerRefDetector struct {
+	mapper meta.RESTMapper
+
+	mu     sync.Mutex
+	cached map[ownerRefPair]bool
+}
+
+type ownerRefPair struct {
+	owner, dependent schema.GroupVersionKind
+}
+
+func newOwnerRefDetector(mapper meta.RESTMapper) *ownerRefDetector {
+	return &ownerRefDetector{
+		mapper: mapper,
+		cached: map[ownerRefPair]bool{},
+	}
+}
+
+// supportsOwnerRef reports whether a resource of ownerGVK can set an owner reference on a
+// resource of dependentGVK. A cluster-scoped dependent resource cannot be owned by a
+// namespace-scoped resource, since owner references cannot cross from namespaced to
+// cluster-scoped; every other combination is supported.
+func (d *ownerRefDetector) supportsOwnerRef(ownerGVK, dependentGVK schema.GroupVersionKind) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := ownerRefPair{owner: ownerGVK, dependent: dependentGVK}
+	if supported, ok := d.cached[key]; ok {
+		return supported, nil
+	}
+
+	ownerMapping, err := d.mapper.RESTMapping(ownerGVK.GroupKind(), ownerGVK.Version)
+	if err != nil {
+		return false, fmt.Errorf("get REST mapping for %s: %w", ownerGVK, err)
+	}
+	dependentMapping, err := d.mapper.RESTMapping(dependentGVK.GroupKind(), dependentGVK.Version)
+	if err != nil {
+		return false, fmt.Errorf("get REST mapping for %s: %w", dependentGVK, err)
+	}
+
+	supported := true
+	if ownerMapping.Scope.Name() == meta.RESTScopeNameNamespace && dependentMapping.Scope.Name() == meta.RESTScopeNameRoot {
+		supported = false
+	}
+
+	d.cached[key] = supported
+	return supported, nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func newTestMapper() meta.RESTMapper {
+	m := meta.NewDefaultRESTMapper(nil)
+	m.Add(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "TestApp"}, meta.RESTScopeNamespace)
+	m.Add(schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Secret"}, meta.RESTScopeNamespace)
+	m.Add(schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	return m
+}
+
+func TestSupportsOwnerRef(t *testing.T) {
+	namespacedOwner := schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "TestApp"}
+	namespacedDependent := schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Secret"}
+	clusterScopedDependent := schema.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"}
+
+	d := newOwnerRefDetector(newTestMapper())
+
+	supported, err := d.supportsOwnerRef(namespacedOwner, namespacedDependent)
+	if err != nil {
+		t.Fatalf("supportsOwnerRef() error = %v", err)
+	}
+	if !supported {
+		t.Fatal("expected a namespaced owner to support owning a namespaced dependent")
+	}
+
+	supported, err = d.supportsOwnerRef(namespacedOwner, clusterScopedDependent)
+	if err != nil {
+		t.Fatalf("supportsOwnerRef() error = %v", err)
+	}
+	if supported {
+		t.Fatal("expected a namespaced owner to NOT support owning a cluster-scoped dependent")
+	}
+
+	// Second call for the same dependent GVK must hit the cache and return the same answer.
+	supported, err = d.supportsOwnerRef(namespacedOwner, clusterScopedDependent)
+	if err != nil {
+		t.Fatalf("supportsOwnerRef() error = %v", err)
+	}
+	if supported {
+		t.Fatal("expected cached result to still be false")
+	}
+}
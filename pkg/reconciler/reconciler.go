@@ -0,0 +1,406 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"helm.sh/helm/v3/pkg/strvals"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/yaml"
+
+	helmclient "github.com/joelanford/helm-operator/pkg/client"
+	"github.com/joelanford/helm-operator/pkg/values"
+)
+
+var log = logf.Log.WithName("reconciler")
+
+// Reconciler reconciles a single custom resource GVK by installing, upgrading, and
+// uninstalling a Helm chart release on its behalf.
+type Reconciler struct {
+	gvk                     schema.GroupVersionKind
+	chart                   *chart.Chart
+	overrideValues          map[string]string
+	valueSources            []values.Source
+	selector                labels.Selector
+	skipDependentWatches    bool
+	maxConcurrentReconciles int
+	reconcilePeriod         time.Duration
+	installAnnotations      []string
+	upgradeAnnotations      []string
+	uninstallAnnotations    []string
+
+	client             crclient.Client
+	actionClientGetter helmclient.ActionClientGetter
+	controller         controller.Controller
+	ownerRefs          *ownerRefDetector
+
+	mu          sync.Mutex
+	watchedGVKs map[schema.GroupVersionKind]bool
+}
+
+// Option configures a Reconciler. Options are applied in New.
+type Option func(*Reconciler) error
+
+func WithChart(c chart.Chart) Option {
+	return func(r *Reconciler) error {
+		r.chart = &c
+		return nil
+	}
+}
+
+func WithGroupVersionKind(gvk schema.GroupVersionKind) Option {
+	return func(r *Reconciler) error {
+		r.gvk = gvk
+		return nil
+	}
+}
+
+func WithOverrideValues(overrides map[string]string) Option {
+	return func(r *Reconciler) error {
+		r.overrideValues = overrides
+		return nil
+	}
+}
+
+// WithValueSources adds pluggable value sources that are resolved and merged, in order, on
+// every reconcile, below any override values.
+func WithValueSources(sources ...values.Source) Option {
+	return func(r *Reconciler) error {
+		r.valueSources = append(r.valueSources, sources...)
+		return nil
+	}
+}
+
+// WithSelector restricts which custom resources this reconciler handles to those matching
+// selector, by filtering events for its primary GVK. Dependent-resource watches are not
+// filtered by it, and it does not configure the manager's cache.
+func WithSelector(selector labels.Selector) Option {
+	return func(r *Reconciler) error {
+		r.selector = selector
+		return nil
+	}
+}
+
+// WithActionClientGetter supplies the Helm action client used to install, upgrade, and
+// uninstall the release this reconciler manages. Required.
+func WithActionClientGetter(getter helmclient.ActionClientGetter) Option {
+	return func(r *Reconciler) error {
+		r.actionClientGetter = getter
+		return nil
+	}
+}
+
+func SkipDependentWatches(skip bool) Option {
+	return func(r *Reconciler) error {
+		r.skipDependentWatches = skip
+		return nil
+	}
+}
+
+func WithMaxConcurrentReconciles(max int) Option {
+	return func(r *Reconciler) error {
+		if max < 1 {
+			return fmt.Errorf("max concurrent reconciles must be at least 1")
+		}
+		r.maxConcurrentReconciles = max
+		return nil
+	}
+}
+
+func WithReconcilePeriod(period time.Duration) Option {
+	return func(r *Reconciler) error {
+		r.reconcilePeriod = period
+		return nil
+	}
+}
+
+// WithInstallAnnotations sets the CR annotation keys, checked in order, whose value (the first
+// one present) becomes the Helm release description recorded against an install.
+func WithInstallAnnotations(annotations ...string) Option {
+	return func(r *Reconciler) error {
+		r.installAnnotations = annotations
+		return nil
+	}
+}
+
+// WithUpgradeAnnotations is WithInstallAnnotations for upgrades.
+func WithUpgradeAnnotations(annotations ...string) Option {
+	return func(r *Reconciler) error {
+		r.upgradeAnnotations = annotations
+		return nil
+	}
+}
+
+// WithUninstallAnnotations is WithInstallAnnotations for uninstalls.
+func WithUninstallAnnotations(annotations ...string) Option {
+	return func(r *Reconciler) error {
+		r.uninstallAnnotations = annotations
+		return nil
+	}
+}
+
+// New creates a Reconciler from opts. WithChart, WithGroupVersionKind, and
+// WithActionClientGetter are required.
+func New(opts ...Option) (*Reconciler, error) {
+	r := &Reconciler{
+		selector:    labels.Everything(),
+		watchedGVKs: map[schema.GroupVersionKind]bool{},
+	}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, fmt.Errorf("apply option: %w", err)
+		}
+	}
+	if r.chart == nil {
+		return nil, fmt.Errorf("chart must be set")
+	}
+	if r.gvk.Empty() {
+		return nil, fmt.Errorf("group/version/kind must be set")
+	}
+	if r.actionClientGetter == nil {
+		return nil, fmt.Errorf("action client getter must be set")
+	}
+	return r, nil
+}
+
+// SetupWithManager registers the reconciler's primary watch with mgr.
+func (r *Reconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.ownerRefs = newOwnerRefDetector(mgr.GetRESTMapper())
+	r.client = mgr.GetClient()
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(r.gvk)
+
+	c, err := builder.ControllerManagedBy(mgr).
+		For(obj, builder.WithPredicates(predicate.NewPredicateFuncs(func(object crclient.Object) bool {
+			return r.selector.Matches(labels.Set(object.GetLabels()))
+		}))).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.maxConcurrentReconciles}).
+		Build(r)
+	if err != nil {
+		return fmt.Errorf("build controller: %w", err)
+	}
+	r.controller = c
+	return nil
+}
+
+// watchDependentResource adds a watch for dependentGVK, enqueueing the owning primary
+// resource's reconcile request whenever a dependent of that GVK changes. It is a no-op if
+// dependent watches are disabled, a watch for dependentGVK is already registered, or the
+// discovery probe determines that dependentGVK cannot carry an owner reference back to the
+// reconciler's primary GVK (e.g. a cluster-scoped dependent owned by a namespaced primary).
+func (r *Reconciler) watchDependentResource(dependentGVK schema.GroupVersionKind) error {
+	if r.skipDependentWatches {
+		return nil
+	}
+
+	r.mu.Lock()
+	alreadyWatched := r.watchedGVKs[dependentGVK]
+	r.mu.Unlock()
+	if alreadyWatched {
+		return nil
+	}
+
+	supported, err := r.ownerRefs.supportsOwnerRef(r.gvk, dependentGVK)
+	if err != nil {
+		return fmt.Errorf("probe owner reference support for %s: %w", dependentGVK, err)
+	}
+	if !supported {
+		log.Info("skipping dependent watch: owner references are not supported for this GVK pair",
+			"owner", r.gvk, "dependent", dependentGVK)
+		return nil
+	}
+
+	dependent := &unstructured.Unstructured{}
+	dependent.SetGroupVersionKind(dependentGVK)
+
+	owner := &unstructured.Unstructured{}
+	owner.SetGroupVersionKind(r.gvk)
+
+	if err := r.controller.Watch(&source.Kind{Type: dependent}, &handler.EnqueueRequestForOwner{OwnerType: owner}); err != nil {
+		return fmt.Errorf("watch dependent resource %s: %w", dependentGVK, err)
+	}
+
+	r.mu.Lock()
+	r.watchedGVKs[dependentGVK] = true
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Reconcile installs, upgrades, or uninstalls the Helm release backing req's custom resource.
+func (r *Reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	cr := &unstructured.Unstructured{}
+	cr.SetGroupVersionKind(r.gvk)
+	if err := r.client.Get(ctx, req.NamespacedName, cr); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("get %s %s: %w", r.gvk, req.NamespacedName, err)
+	}
+
+	actionClient, err := r.actionClientGetter.ActionClientFor(r.gvk, req.Namespace)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("get helm action client for %s: %w", r.gvk, err)
+	}
+
+	if !cr.GetDeletionTimestamp().IsZero() {
+		description := releaseDescription(cr, r.uninstallAnnotations)
+		if _, err := actionClient.Uninstall(req.Name, description); err != nil && err != driver.ErrReleaseNotFound {
+			return ctrl.Result{}, fmt.Errorf("uninstall release %q: %w", req.Name, err)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	sources := make([]values.Source, 0, len(r.valueSources)+2)
+	sources = append(sources, r.valueSources...)
+	sources = append(sources, crSpecValueSource{})
+	sources = append(sources, overrideValueSource(r.overrideValues))
+	mergedValues, valuesHash, err := values.Merge(ctx, cr, sources)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("resolve values: %w", err)
+	}
+
+	var rel *release.Release
+	if _, err := actionClient.Get(req.Name); err == driver.ErrReleaseNotFound {
+		rel, err = actionClient.Install(req.Name, r.chart, mergedValues, releaseDescription(cr, r.installAnnotations))
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("install release %q: %w", req.Name, err)
+		}
+	} else if err != nil {
+		return ctrl.Result{}, fmt.Errorf("get release %q: %w", req.Name, err)
+	} else {
+		rel, err = actionClient.Upgrade(req.Name, r.chart, mergedValues, releaseDescription(cr, r.upgradeAnnotations))
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("upgrade release %q: %w", req.Name, err)
+		}
+	}
+
+	dependentGVKs, err := manifestGVKs(rel.Manifest)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("parse release manifest for %q: %w", req.Name, err)
+	}
+	for _, dependentGVK := range dependentGVKs {
+		if err := r.watchDependentResource(dependentGVK); err != nil {
+			return ctrl.Result{}, fmt.Errorf("watch dependent resource %s: %w", dependentGVK, err)
+		}
+	}
+
+	if err := setValuesHash(ctx, r.client, cr, valuesHash); err != nil {
+		return ctrl.Result{}, fmt.Errorf("record resolved values hash in status: %w", err)
+	}
+
+	return ctrl.Result{RequeueAfter: r.reconcilePeriod}, nil
+}
+
+// crSpecValueSource supplies the reconciled custom resource's own spec as values. Reconcile
+// places it after the pluggable value sources and before the override values.
+type crSpecValueSource struct{}
+
+func (crSpecValueSource) Resolve(_ context.Context, cr *unstructured.Unstructured) (chartutil.Values, error) {
+	spec, _, err := unstructured.NestedMap(cr.Object, "spec")
+	if err != nil {
+		return nil, fmt.Errorf("read spec: %w", err)
+	}
+	return spec, nil
+}
+
+// overrideValueSource adapts Reconciler's --set-style overrideValues (dotted path=value
+// strings) into a values.Source, using Helm's own --set syntax so the same dotted-path
+// conventions apply. It is appended last in Reconcile's source list, so overrides always win.
+type overrideValueSource map[string]string
+
+func (s overrideValueSource) Resolve(_ context.Context, _ *unstructured.Unstructured) (chartutil.Values, error) {
+	v := chartutil.Values{}
+	for path, val := range s {
+		if err := strvals.ParseInto(fmt.Sprintf("%s=%s", path, val), v); err != nil {
+			return nil, fmt.Errorf("parse override value %q: %w", path, err)
+		}
+	}
+	return v, nil
+}
+
+// releaseDescription returns the value of the first of keys set on cr's annotations, or "" if
+// none are. Reconcile passes the result straight through as the Helm release description for
+// the corresponding lifecycle action.
+func releaseDescription(cr *unstructured.Unstructured, keys []string) string {
+	annotations := cr.GetAnnotations()
+	for _, k := range keys {
+		if v, ok := annotations[k]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// manifestGVKs returns the distinct GroupVersionKinds rendered into manifest, so Reconcile can
+// register exactly the dependent watches this release actually needs. The order of the result
+// is not defined, since releaseutil.SplitManifests returns a map.
+func manifestGVKs(manifest string) ([]schema.GroupVersionKind, error) {
+	seen := map[schema.GroupVersionKind]bool{}
+	var gvks []schema.GroupVersionKind
+	for _, doc := range releaseutil.SplitManifests(manifest) {
+		var typeMeta struct {
+			APIVersion string `json:"apiVersion"`
+			Kind       string `json:"kind"`
+		}
+		if err := yaml.Unmarshal([]byte(doc), &typeMeta); err != nil {
+			return nil, fmt.Errorf("parse rendered manifest: %w", err)
+		}
+		if typeMeta.Kind == "" {
+			continue
+		}
+		gvk := schema.FromAPIVersionAndKind(typeMeta.APIVersion, typeMeta.Kind)
+		if seen[gvk] {
+			continue
+		}
+		seen[gvk] = true
+		gvks = append(gvks, gvk)
+	}
+	return gvks, nil
+}
+
+// setValuesHash records hash, the hash of this reconcile's freshly-resolved values, in the
+// custom resource's status, so drift in a value source - not just the CR's own spec - is
+// visible on the CR. Reconcile does not compare it with the previously recorded hash; it
+// upgrades the release on every reconcile.
+func setValuesHash(ctx context.Context, c crclient.Client, cr *unstructured.Unstructured, hash string) error {
+	if err := unstructured.SetNestedField(cr.Object, hash, "status", "valuesHash"); err != nil {
+		return fmt.Errorf("set status.valuesHash: %w", err)
+	}
+	return c.Status().Update(ctx, cr)
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package values
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// EnvSource resolves values from a single environment variable on the
+// operator process, parsed as YAML. It is primarily useful for cluster-wide
+// defaults baked into the operator's Deployment spec.
+type EnvSource struct {
+	// Name is the environment variable to read.
+	Name string
+}
+
+func (s EnvSource) Resolve(_ context.Context, _ *unstructured.Unstructured) (chartutil.Values, error) {
+	raw, ok := os.LookupEnv(s.Name)
+	if !ok || raw == "" {
+		return chartutil.Values{}, nil
+	}
+	return parseYAMLValues([]byte(raw))
+}
+
+// ConfigMapSource resolves values from a key (default "values.yaml") in a
+// ConfigMap, letting platform teams layer shared defaults under per-CR
+// spec values without baking them into the CR.
+type ConfigMapSource struct {
+	Client    client.Client
+	Namespace string
+	Name      string
+	Key       string
+}
+
+func (s ConfigMapSource) Resolve(ctx context.Context, _ *unstructured.Unstructured) (chartutil.Values, error) {
+	key := s.Key
+	if key == "" {
+		key = "values.yaml"
+	}
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	if err := s.Client.Get(ctx, types.NamespacedName{Namespace: s.Namespace, Name: s.Name}, cm); err != nil {
+		return nil, fmt.Errorf("get configmap %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	data, _, err := unstructured.NestedStringMap(cm.Object, "data")
+	if err != nil {
+		return nil, fmt.Errorf("read data from configmap %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	raw, ok := data[key]
+	if !ok {
+		return chartutil.Values{}, nil
+	}
+	return parseYAMLValues([]byte(raw))
+}
+
+// SecretSource resolves values from a key (default "values.yaml") in a
+// Secret. Use this for values that must not be stored on the CR itself,
+// such as credentials a chart expects as plain values.
+type SecretSource struct {
+	Client    client.Client
+	Namespace string
+	Name      string
+	Key       string
+}
+
+func (s SecretSource) Resolve(ctx context.Context, _ *unstructured.Unstructured) (chartutil.Values, error) {
+	key := s.Key
+	if key == "" {
+		key = "values.yaml"
+	}
+	secret := &unstructured.Unstructured{}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	if err := s.Client.Get(ctx, types.NamespacedName{Namespace: s.Namespace, Name: s.Name}, secret); err != nil {
+		return nil, fmt.Errorf("get secret %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	data, found, err := unstructured.NestedMap(secret.Object, "data")
+	if err != nil {
+		return nil, fmt.Errorf("read data from secret %s/%s: %w", s.Namespace, s.Name, err)
+	}
+	if !found {
+		return chartutil.Values{}, nil
+	}
+	raw, ok := data[key].(string)
+	if !ok {
+		return chartutil.Values{}, nil
+	}
+	decoded, err := decodeBase64(raw)
+	if err != nil {
+		return nil, fmt.Errorf("decode secret %s/%s key %q: %w", s.Namespace, s.Name, key, err)
+	}
+	return parseYAMLValues(decoded)
+}
+
+// DownwardAPISource projects fields from the reconciled custom resource
+// itself into a values path, analogous to a Kubernetes downward API volume
+// but for Helm values instead of container env/files.
+type DownwardAPISource struct {
+	// Fields maps a dotted values path (e.g. "global.releaseName") to a
+	// field path on the CR (e.g. "metadata.name").
+	Fields map[string]string
+}
+
+func (s DownwardAPISource) Resolve(_ context.Context, cr *unstructured.Unstructured) (chartutil.Values, error) {
+	out := chartutil.Values{}
+	for valuesPath, fieldPath := range s.Fields {
+		val, found, err := unstructured.NestedFieldNoCopy(cr.Object, strings.Split(fieldPath, ".")...)
+		if err != nil {
+			return nil, fmt.Errorf("read field %q from custom resource: %w", fieldPath, err)
+		}
+		if !found {
+			continue
+		}
+		if err := setValuesPath(out, strings.Split(valuesPath, "."), val); err != nil {
+			return nil, fmt.Errorf("set values path %q: %w", valuesPath, err)
+		}
+	}
+	return out, nil
+}
+
+// ExecSource resolves values by running an arbitrary command, piping the
+// custom resource to it as JSON on stdin, and parsing its stdout as YAML
+// values. This is the escape hatch for value sources (e.g. Vault) that
+// don't warrant a dedicated built-in Source.
+type ExecSource struct {
+	Command string
+	Args    []string
+}
+
+func (s ExecSource) Resolve(ctx context.Context, cr *unstructured.Unstructured) (chartutil.Values, error) {
+	crJSON, err := cr.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("marshal custom resource: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, s.Command, s.Args...)
+	cmd.Stdin = bytes.NewReader(crJSON)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("exec value source %q: %w: %s", s.Command, err, stderr.String())
+	}
+	return parseYAMLValues(stdout.Bytes())
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// parseYAMLValues uses sigs.k8s.io/yaml (YAML -> JSON -> encoding/json), the same library
+// chartutil.ReadValues uses, so nested mappings decode as map[string]interface{} and are
+// recognized as tables by chartutil.CoalesceTables and are JSON-marshalable for hashing.
+// gopkg.in/yaml.v2 would decode them as map[interface{}]interface{} instead, breaking both.
+func parseYAMLValues(raw []byte) (chartutil.Values, error) {
+	v := chartutil.Values{}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return v, nil
+	}
+	if err := yaml.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("parse values: %w", err)
+	}
+	return v, nil
+}
+
+// setValuesPath sets a nested value in a chartutil.Values, creating
+// intermediate maps as needed. Intermediate maps are built as plain
+// map[string]interface{}, the exact type chartutil.CoalesceTables type-asserts
+// against to recognize a value as a mergeable table - the named chartutil.Values
+// type itself would not match that assertion.
+func setValuesPath(v chartutil.Values, path []string, val interface{}) error {
+	cur := map[string]interface{}(v)
+	for i, p := range path {
+		if i == len(path)-1 {
+			cur[p] = val
+			return nil
+		}
+		next, ok := cur[p]
+		if !ok {
+			m := map[string]interface{}{}
+			cur[p] = m
+			cur = m
+			continue
+		}
+		m, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("path element %q is not a map", p)
+		}
+		cur = m
+	}
+	return nil
+}
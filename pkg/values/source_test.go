@@ -0,0 +1,152 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package values
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// singleObjectGetter is a minimal client.Client fake that serves Get for one
+// pre-loaded object and panics on anything else; it embeds client.Client so it
+// only needs to implement the one method these tests exercise.
+type singleObjectGetter struct {
+	client.Client
+	obj *unstructured.Unstructured
+}
+
+func (g singleObjectGetter) Get(_ context.Context, _ types.NamespacedName, obj client.Object, _ ...client.GetOption) error {
+	u := obj.(*unstructured.Unstructured)
+	u.Object = g.obj.Object
+	return nil
+}
+
+func imageTag(t *testing.T, v chartutil.Values) (string, string) {
+	t.Helper()
+	image, ok := v["image"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected image to decode as map[string]interface{}, got %T", v["image"])
+	}
+	repo, _ := image["repository"].(string)
+	tag, _ := image["tag"].(string)
+	return repo, tag
+}
+
+func TestEnvSourceDecodesNestedMappingsAsStringMaps(t *testing.T) {
+	const name = "HELM_OPERATOR_TEST_VALUES"
+	if err := os.Setenv(name, "image:\n  repository: example.com/app\n  tag: v1\n"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(name)
+
+	v, err := (EnvSource{Name: name}).Resolve(context.Background(), &unstructured.Unstructured{})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	repo, tag := imageTag(t, v)
+	if repo != "example.com/app" || tag != "v1" {
+		t.Fatalf("got repository=%q tag=%q", repo, tag)
+	}
+}
+
+func TestConfigMapSourceDecodesNestedMappingsAsStringMaps(t *testing.T) {
+	cm := &unstructured.Unstructured{}
+	cm.SetAPIVersion("v1")
+	cm.SetKind("ConfigMap")
+	if err := unstructured.SetNestedStringMap(cm.Object, map[string]string{
+		"values.yaml": "image:\n  repository: example.com/app\n  tag: v1\n",
+	}, "data"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := ConfigMapSource{Client: singleObjectGetter{obj: cm}, Namespace: "ns", Name: "defaults"}
+
+	v, err := s.Resolve(context.Background(), &unstructured.Unstructured{})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	repo, tag := imageTag(t, v)
+	if repo != "example.com/app" || tag != "v1" {
+		t.Fatalf("got repository=%q tag=%q", repo, tag)
+	}
+}
+
+func TestSecretSourceDecodesNestedMappingsAsStringMaps(t *testing.T) {
+	raw := "image:\n  repository: example.com/app\n  tag: v1\n"
+	secret := &unstructured.Unstructured{}
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	if err := unstructured.SetNestedStringMap(secret.Object, map[string]string{
+		"values.yaml": base64.StdEncoding.EncodeToString([]byte(raw)),
+	}, "data"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := SecretSource{Client: singleObjectGetter{obj: secret}, Namespace: "ns", Name: "defaults"}
+
+	v, err := s.Resolve(context.Background(), &unstructured.Unstructured{})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	repo, tag := imageTag(t, v)
+	if repo != "example.com/app" || tag != "v1" {
+		t.Fatalf("got repository=%q tag=%q", repo, tag)
+	}
+}
+
+// TestMergeDeepMergesNestedTablesAcrossSources guards against the exact regression that
+// gopkg.in/yaml.v2 caused: a lower-priority source's nested map must survive, with only the
+// overlapping leaf keys overridden, once a higher-priority source sets just part of the same
+// nested map.
+func TestMergeDeepMergesNestedTablesAcrossSources(t *testing.T) {
+	const name = "HELM_OPERATOR_TEST_VALUES_MERGE"
+	if err := os.Setenv(name, "image:\n  repository: example.com/app\n  tag: v1\n"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(name)
+
+	sources := []Source{
+		EnvSource{Name: name},
+		DownwardAPISource{Fields: map[string]string{"image.tag": "metadata.name"}},
+	}
+
+	cr := &unstructured.Unstructured{}
+	cr.SetName("v2")
+
+	merged, _, err := Merge(context.Background(), cr, sources)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+
+	repo, tag := imageTag(t, merged)
+	if repo != "example.com/app" {
+		t.Fatalf("expected lower-priority repository to survive the merge, got %q", repo)
+	}
+	if tag != "v2" {
+		t.Fatalf("expected higher-priority tag to win, got %q", tag)
+	}
+}
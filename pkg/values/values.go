@@ -0,0 +1,72 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package values provides pluggable sources of Helm chart values that the
+// reconciler merges with a custom resource's spec before every release
+// install/upgrade. This lets platform operators layer defaults (e.g. from a
+// ConfigMap or Secret) underneath per-CR values without baking them into the
+// CR itself.
+package values
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Source resolves a set of Helm values for a given custom resource. Sources
+// are re-resolved on every reconcile, so implementations should be cheap or
+// cache internally if a remote call is expensive.
+type Source interface {
+	Resolve(ctx context.Context, cr *unstructured.Unstructured) (chartutil.Values, error)
+}
+
+// Merge resolves every source in order and merges the results into a single
+// set of values, with later sources taking priority over earlier ones. It
+// also returns a stable hash of the merged result so callers can detect
+// drift between reconciles without re-rendering the chart.
+func Merge(ctx context.Context, cr *unstructured.Unstructured, sources []Source) (chartutil.Values, string, error) {
+	merged := chartutil.Values{}
+	for i, s := range sources {
+		v, err := s.Resolve(ctx, cr)
+		if err != nil {
+			return nil, "", fmt.Errorf("resolve value source %d: %w", i, err)
+		}
+		merged = chartutil.CoalesceTables(v, merged)
+	}
+
+	hash, err := hashValues(merged)
+	if err != nil {
+		return nil, "", fmt.Errorf("hash merged values: %w", err)
+	}
+	return merged, hash, nil
+}
+
+// hashValues returns a stable hex-encoded sha256 of a values set, used to
+// detect when resolved values have drifted since the last reconcile.
+func hashValues(v chartutil.Values) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2020 The Operator-SDK Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package values
+
+import (
+	"context"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+type staticSource chartutil.Values
+
+func (s staticSource) Resolve(_ context.Context, _ *unstructured.Unstructured) (chartutil.Values, error) {
+	return chartutil.Values(s), nil
+}
+
+func TestMergePriority(t *testing.T) {
+	sources := []Source{
+		staticSource{"replicaCount": 1, "image": chartutil.Values{"tag": "v1"}},
+		staticSource{"image": chartutil.Values{"tag": "v2"}},
+	}
+
+	merged, hash, err := Merge(context.Background(), &unstructured.Unstructured{}, sources)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if hash == "" {
+		t.Fatal("Merge() returned empty hash")
+	}
+
+	image, ok := merged["image"].(chartutil.Values)
+	if !ok {
+		t.Fatalf("expected image to be a map, got %T", merged["image"])
+	}
+	if got := image["tag"]; got != "v2" {
+		t.Fatalf("expected later source to win, got tag = %v", got)
+	}
+	if got := merged["replicaCount"]; got != 1 {
+		t.Fatalf("expected earlier-only key to survive, got replicaCount = %v", got)
+	}
+}
+
+func TestMergeHashIsStableAndDriftDetecting(t *testing.T) {
+	a := []Source{staticSource{"replicaCount": 1}}
+	b := []Source{staticSource{"replicaCount": 2}}
+
+	_, hashA, err := Merge(context.Background(), &unstructured.Unstructured{}, a)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	_, hashARepeat, err := Merge(context.Background(), &unstructured.Unstructured{}, a)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if hashA != hashARepeat {
+		t.Fatalf("expected identical values to hash identically, got %q and %q", hashA, hashARepeat)
+	}
+
+	_, hashB, err := Merge(context.Background(), &unstructured.Unstructured{}, b)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if hashA == hashB {
+		t.Fatal("expected different values to hash differently")
+	}
+}